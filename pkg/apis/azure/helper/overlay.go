@@ -0,0 +1,55 @@
+// Copyright (c) 2019 SAP SE or an SAP affiliate company. All rights reserved. This file is licensed under the Apache Software License, v. 2 except as noted otherwise in the LICENSE file
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package helper
+
+import (
+	"encoding/json"
+
+	extensionscontroller "github.com/gardener/gardener/extensions/pkg/controller"
+)
+
+// networkingProviderConfig is the subset of the shoot's network extension provider config that
+// IsOverlayDisabled needs to inspect.
+type networkingProviderConfig struct {
+	Overlay *struct {
+		Enabled bool `json:"enabled"`
+	} `json:"overlay,omitempty"`
+}
+
+// IsOverlayDisabled returns true if the given Cluster's shoot is configured with an overlay-free (routed) pod
+// network, i.e. per-zone subnet/NAT gateway identity does not need to be preserved across network layout changes.
+// It returns false if the cluster, its shoot networking config, or the overlay setting cannot be determined, since
+// that is the layout in which preserving subnet identity matters.
+//
+// This is meant to be the single predicate both the infrastructure mutating webhook and the (future) validating
+// webhook/reconciler consult. Only the mutating webhook in pkg/webhook/infrastructure calls it so far; wiring it
+// into the validating webhook and reconciler is tracked as explicit follow-up work, not done here.
+func IsOverlayDisabled(cluster *extensionscontroller.Cluster) bool {
+	if cluster == nil || cluster.Shoot == nil {
+		return false
+	}
+
+	networking := cluster.Shoot.Spec.Networking
+	if networking == nil || networking.ProviderConfig == nil {
+		return false
+	}
+
+	config := &networkingProviderConfig{}
+	if err := json.Unmarshal(networking.ProviderConfig.Raw, config); err != nil {
+		return false
+	}
+
+	return config.Overlay != nil && !config.Overlay.Enabled
+}
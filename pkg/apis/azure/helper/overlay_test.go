@@ -0,0 +1,48 @@
+// Copyright (c) 2019 SAP SE or an SAP affiliate company. All rights reserved. This file is licensed under the Apache Software License, v. 2 except as noted otherwise in the LICENSE file
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package helper_test
+
+import (
+	"testing"
+
+	"github.com/gardener/gardener-extension-provider-azure/pkg/apis/azure/helper"
+	extensionscontroller "github.com/gardener/gardener/extensions/pkg/controller"
+	gardencorev1beta1 "github.com/gardener/gardener/pkg/apis/core/v1beta1"
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func clusterWithOverlay(raw string) *extensionscontroller.Cluster {
+	return &extensionscontroller.Cluster{
+		Shoot: &gardencorev1beta1.Shoot{
+			Spec: gardencorev1beta1.ShootSpec{
+				Networking: &gardencorev1beta1.Networking{
+					ProviderConfig: &runtime.RawExtension{Raw: []byte(raw)},
+				},
+			},
+		},
+	}
+}
+
+func TestIsOverlayDisabled(t *testing.T) {
+	g := NewWithT(t)
+
+	g.Expect(helper.IsOverlayDisabled(nil)).To(BeFalse())
+	g.Expect(helper.IsOverlayDisabled(&extensionscontroller.Cluster{})).To(BeFalse())
+	g.Expect(helper.IsOverlayDisabled(&extensionscontroller.Cluster{Shoot: &gardencorev1beta1.Shoot{}})).To(BeFalse())
+	g.Expect(helper.IsOverlayDisabled(clusterWithOverlay(`{"overlay":{"enabled":true}}`))).To(BeFalse())
+	g.Expect(helper.IsOverlayDisabled(clusterWithOverlay(`{"overlay":{"enabled":false}}`))).To(BeTrue())
+	g.Expect(helper.IsOverlayDisabled(clusterWithOverlay(`{}`))).To(BeFalse())
+}
@@ -0,0 +1,67 @@
+// Copyright (c) 2019 SAP SE or an SAP affiliate company. All rights reserved. This file is licensed under the Apache Software License, v. 2 except as noted otherwise in the LICENSE file
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package azure
+
+import "encoding/json"
+
+const (
+	// NetworkLayoutZoneMigrationAnnotation is the annotation on an Infrastructure resource that the infrastructure
+	// webhook uses to record a migration of the network layout between a flat layout (a single Networks.Workers
+	// CIDR) and a zoned layout (per-zone CIDRs in Networks.Zones). Its value is either the plain name of the zone
+	// that inherited the flat CIDR verbatim (e.g. "2"), or a JSON object mapping zone name to CIDR (e.g.
+	// {"1":"10.0.0.0/17","2":"10.0.128.0/17"}) when the flat CIDR was carved up across several new zones. It is
+	// meant to be consumed by the terraform reconciler to import the pre-existing subnet(s) instead of recreating
+	// them, via DecodeNetworkLayoutZoneMigration, but no reconciler/terraform package exists in this tree to wire
+	// that up in; doing so is untracked follow-up work, not delivered by the change that added this annotation.
+	NetworkLayoutZoneMigrationAnnotation = "azure.provider.extensions.gardener.cloud/network-layout-zone-migration"
+
+	// NatGatewayZoneMigrationAnnotation is the annotation on an Infrastructure resource that the infrastructure
+	// webhook uses to record that an existing flat-layout NAT gateway has to be re-associated with the given zone
+	// of a zoned layout. Its value is the plain name of the zone that inherited the gateway (e.g. "2"). It is meant
+	// to be consumed by the terraform reconciler, via DecodeNatGatewayZoneMigration, to import the existing NAT
+	// gateway/public IP into that zone's state instead of destroying and recreating it, which would otherwise drop
+	// the shoot's egress IP — but no reconciler/terraform package exists in this tree to wire that up in; doing so
+	// is untracked follow-up work, not delivered by the change that added this annotation.
+	NatGatewayZoneMigrationAnnotation = "azure.provider.extensions.gardener.cloud/nat-gateway-zone-migration"
+)
+
+// DecodeNetworkLayoutZoneMigration decodes the value of NetworkLayoutZoneMigrationAnnotation into a mapping from
+// zone name to the CIDR that zone inherited. It accepts both the JSON object form and the legacy plain-integer
+// form, in which case fallbackCIDR (typically the CIDR the flat layout's Workers subnet had) is used as the CIDR
+// for the single migrated zone. It returns false if the annotation is not set. This is the decoder a terraform
+// reconciler would use to determine which subnet(s) to import instead of recreating; nothing in this repository
+// calls it yet.
+func DecodeNetworkLayoutZoneMigration(annotations map[string]string, fallbackCIDR string) (map[string]string, bool) {
+	value, ok := annotations[NetworkLayoutZoneMigrationAnnotation]
+	if !ok {
+		return nil, false
+	}
+
+	var mapping map[string]string
+	if err := json.Unmarshal([]byte(value), &mapping); err == nil {
+		return mapping, true
+	}
+
+	return map[string]string{value: fallbackCIDR}, true
+}
+
+// DecodeNatGatewayZoneMigration decodes the value of NatGatewayZoneMigrationAnnotation into the name of the zone
+// that inherited the NAT gateway. It returns false if the annotation is not set. This is the decoder a terraform
+// reconciler would use to determine which zone's state the existing gateway/public IP should be imported into;
+// nothing in this repository calls it yet.
+func DecodeNatGatewayZoneMigration(annotations map[string]string) (string, bool) {
+	zoneName, ok := annotations[NatGatewayZoneMigrationAnnotation]
+	return zoneName, ok
+}
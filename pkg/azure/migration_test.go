@@ -0,0 +1,54 @@
+// Copyright (c) 2019 SAP SE or an SAP affiliate company. All rights reserved. This file is licensed under the Apache Software License, v. 2 except as noted otherwise in the LICENSE file
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package azure_test
+
+import (
+	"testing"
+
+	"github.com/gardener/gardener-extension-provider-azure/pkg/azure"
+	. "github.com/onsi/gomega"
+)
+
+func TestDecodeNetworkLayoutZoneMigration(t *testing.T) {
+	g := NewWithT(t)
+
+	_, ok := azure.DecodeNetworkLayoutZoneMigration(map[string]string{}, "10.0.0.0/16")
+	g.Expect(ok).To(BeFalse())
+
+	mapping, ok := azure.DecodeNetworkLayoutZoneMigration(map[string]string{
+		azure.NetworkLayoutZoneMigrationAnnotation: "2",
+	}, "10.0.0.0/16")
+	g.Expect(ok).To(BeTrue())
+	g.Expect(mapping).To(Equal(map[string]string{"2": "10.0.0.0/16"}))
+
+	mapping, ok = azure.DecodeNetworkLayoutZoneMigration(map[string]string{
+		azure.NetworkLayoutZoneMigrationAnnotation: `{"1":"10.0.0.0/17","2":"10.0.128.0/17"}`,
+	}, "10.0.0.0/16")
+	g.Expect(ok).To(BeTrue())
+	g.Expect(mapping).To(Equal(map[string]string{"1": "10.0.0.0/17", "2": "10.0.128.0/17"}))
+}
+
+func TestDecodeNatGatewayZoneMigration(t *testing.T) {
+	g := NewWithT(t)
+
+	_, ok := azure.DecodeNatGatewayZoneMigration(map[string]string{})
+	g.Expect(ok).To(BeFalse())
+
+	zoneName, ok := azure.DecodeNatGatewayZoneMigration(map[string]string{
+		azure.NatGatewayZoneMigrationAnnotation: "2",
+	})
+	g.Expect(ok).To(BeTrue())
+	g.Expect(zoneName).To(Equal("2"))
+}
@@ -0,0 +1,358 @@
+// Copyright (c) 2019 SAP SE or an SAP affiliate company. All rights reserved. This file is licensed under the Apache Software License, v. 2 except as noted otherwise in the LICENSE file
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package infrastructure
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strconv"
+
+	azurehelper "github.com/gardener/gardener-extension-provider-azure/pkg/apis/azure/helper"
+	azurev1alpha1 "github.com/gardener/gardener-extension-provider-azure/pkg/apis/azure/v1alpha1"
+	"github.com/gardener/gardener-extension-provider-azure/pkg/azure"
+	extensionscontroller "github.com/gardener/gardener/extensions/pkg/controller"
+	extensionswebhook "github.com/gardener/gardener/extensions/pkg/webhook"
+	extensionsv1alpha1 "github.com/gardener/gardener/pkg/apis/extensions/v1alpha1"
+	"github.com/go-logr/logr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+var logger = log.Log.WithName("azure-infrastructure-webhook")
+
+// MutateFunc mutates the given Infrastructure resource (new), taking the previous state of the resource (old)
+// into account. old is nil for create operations. c is the mutator's client, which may be nil if none has been
+// injected yet.
+type MutateFunc func(ctx context.Context, c client.Client, new, old *extensionsv1alpha1.Infrastructure) error
+
+type mutator struct {
+	client      client.Client
+	logger      logr.Logger
+	mutateFuncs []MutateFunc
+}
+
+// New creates a new extensionswebhook.Mutator that applies the given mutate functions to Infrastructure
+// resources, in order.
+func New(logger logr.Logger, mutateFuncs ...MutateFunc) extensionswebhook.Mutator {
+	return &mutator{
+		logger:      logger.WithName("mutator"),
+		mutateFuncs: mutateFuncs,
+	}
+}
+
+// InjectClient injects the given client into the mutator.
+func (m *mutator) InjectClient(c client.Client) error {
+	m.client = c
+	return nil
+}
+
+// Mutate implements extensionswebhook.Mutator.
+func (m *mutator) Mutate(ctx context.Context, newObj, oldObj client.Object) error {
+	newInfra, ok := newObj.(*extensionsv1alpha1.Infrastructure)
+	if !ok {
+		return nil
+	}
+
+	var oldInfra *extensionsv1alpha1.Infrastructure
+	if oldObj != nil {
+		oldInfra, ok = oldObj.(*extensionsv1alpha1.Infrastructure)
+		if !ok {
+			return nil
+		}
+	}
+
+	for _, mutateFunc := range m.mutateFuncs {
+		if err := mutateFunc(ctx, m.client, newInfra, oldInfra); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// NetworkLayoutMigrationMutate stamps the Infrastructure resource with azure.NetworkLayoutZoneMigrationAnnotation
+// whenever the network layout is migrated between a flat layout (a single Networks.Workers CIDR) and a zoned
+// layout (per-zone CIDRs in Networks.Zones), so that the terraform reconciler can import the pre-existing subnet(s)
+// instead of destroying and recreating them. The annotation value is either the plain zone name (e.g. "2") when a
+// single zone reuses the exact Workers CIDR, or a JSON object mapping zone name to CIDR (e.g.
+// {"1":"10.0.0.0/17","2":"10.0.128.0/17"}) when the Workers CIDR is carved up across several new zones.
+//
+// Mutation is skipped entirely for shoots with an overlay-disabled network, since per-zone subnet/NAT gateway
+// identity is irrelevant when the routing mode does not rely on it. If no client has been injected yet, the
+// cluster cannot be looked up and mutation proceeds as usual.
+//
+// azure.DecodeNetworkLayoutZoneMigration is the decoder a terraform reconciler would use to actually import the
+// preserved subnet(s), but no reconciler/terraform package exists in this repository to wire that decoder into;
+// this change only adds the webhook-side bookkeeping, not the reconciler-side import behavior itself.
+//
+// The same migration can also stamp azure.NatGatewayZoneMigrationAnnotation if the flat layout's NAT gateway has to
+// move with it. azure.DecodeNatGatewayZoneMigration is the corresponding decoder a terraform reconciler would use
+// to actually import the existing gateway/public IP, but — as with the network layout annotation above — no such
+// reconciler wiring exists in this repository yet; it is untracked follow-up work. Both annotations are pruned
+// once the zone(s) they refer to are no longer present in the config.
+func NetworkLayoutMigrationMutate(ctx context.Context, c client.Client, new, old *extensionsv1alpha1.Infrastructure) error {
+	if old == nil {
+		return nil
+	}
+
+	if c != nil {
+		cluster, err := extensionscontroller.GetCluster(ctx, c, new.Namespace)
+		if err != nil {
+			return fmt.Errorf("could not get cluster for infrastructure %q: %w", new.Name, err)
+		}
+		if azurehelper.IsOverlayDisabled(cluster) {
+			return nil
+		}
+	}
+
+	newConfig, err := decodeInfrastructureConfig(new)
+	if err != nil || newConfig == nil {
+		return err
+	}
+
+	oldConfig, err := decodeInfrastructureConfig(old)
+	if err != nil || oldConfig == nil {
+		return err
+	}
+
+	oldZonedLayout := len(oldConfig.Networks.Zones) > 0
+	newZonedLayout := len(newConfig.Networks.Zones) > 0
+
+	switch {
+	case !oldZonedLayout && newZonedLayout:
+		return mutateFlatToZoned(new, oldConfig, newConfig)
+	case oldZonedLayout && !newZonedLayout:
+		return mutateZonedToFlat(new, oldConfig, newConfig)
+	default:
+		if err := pruneStaleLayoutMigrationAnnotation(new, newConfig); err != nil {
+			return err
+		}
+		pruneStaleNatGatewayMigrationAnnotation(new, newConfig)
+		return nil
+	}
+}
+
+// mutateFlatToZoned detects a migration from a flat Workers CIDR to a zoned layout, records which new zone(s)
+// took over the existing subnet(s), and carries the NAT gateway along if it needs to move with them.
+func mutateFlatToZoned(new *extensionsv1alpha1.Infrastructure, oldConfig, newConfig *azurev1alpha1.InfrastructureConfig) error {
+	inheritZone, inheritZoneOK, err := mutateNetworkLayoutFlatToZoned(new, oldConfig, newConfig)
+	if err != nil {
+		return err
+	}
+
+	return mutateNatGatewayFlatToZoned(new, oldConfig, newConfig, inheritZone, inheritZoneOK)
+}
+
+// mutateNetworkLayoutFlatToZoned implements the subnet half of mutateFlatToZoned. When exactly one zone reuses the
+// Workers CIDR verbatim, it also returns that zone's name so the NAT gateway migration can default to it.
+func mutateNetworkLayoutFlatToZoned(new *extensionsv1alpha1.Infrastructure, oldConfig, newConfig *azurev1alpha1.InfrastructureConfig) (int32, bool, error) {
+	if oldConfig.Networks.Workers == nil {
+		return 0, false, nil
+	}
+	workersCIDR := *oldConfig.Networks.Workers
+
+	_, workersNet, err := net.ParseCIDR(workersCIDR)
+	if err != nil {
+		return 0, false, nil
+	}
+
+	migrated := map[int32]string{}
+	for _, zone := range newConfig.Networks.Zones {
+		if zone.CIDR == workersCIDR {
+			migrated[zone.Name] = zone.CIDR
+			continue
+		}
+		if ip, _, err := net.ParseCIDR(zone.CIDR); err == nil && workersNet.Contains(ip) {
+			migrated[zone.Name] = zone.CIDR
+		}
+	}
+
+	switch len(migrated) {
+	case 0:
+		return 0, false, nil
+	case 1:
+		for name, cidr := range migrated {
+			if cidr == workersCIDR {
+				setLayoutMigrationAnnotation(new, strconv.Itoa(int(name)))
+				return name, true, nil
+			}
+		}
+		return 0, false, setLayoutMigrationMapping(new, migrated)
+	default:
+		return 0, false, setLayoutMigrationMapping(new, migrated)
+	}
+}
+
+// mutateNatGatewayFlatToZoned detects whether the flat layout's NAT gateway has to be re-associated with a zone,
+// either because the user explicitly pinned a zone's NatGateway.Zone to the gateway's original availability zone,
+// or because the gateway's subnet was implicitly inherited by the zone the CIDR migration picked. Either way, the
+// migration is recorded so the terraform reconciler imports the existing gateway/public IP instead of recreating
+// it, which would otherwise drop the shoot's egress IP.
+func mutateNatGatewayFlatToZoned(new *extensionsv1alpha1.Infrastructure, oldConfig, newConfig *azurev1alpha1.InfrastructureConfig, inheritZone int32, inheritZoneOK bool) error {
+	oldGateway := oldConfig.Networks.NatGateway
+	if oldGateway == nil || !oldGateway.Enabled {
+		return nil
+	}
+
+	if oldGateway.Zone != nil {
+		for _, zone := range newConfig.Networks.Zones {
+			if zone.NatGateway != nil && zone.NatGateway.Zone != nil && *zone.NatGateway.Zone == *oldGateway.Zone {
+				setNatGatewayMigrationAnnotation(new, zone.Name)
+				return nil
+			}
+		}
+	}
+
+	if inheritZoneOK {
+		for _, zone := range newConfig.Networks.Zones {
+			if zone.Name == inheritZone && zone.NatGateway != nil && zone.NatGateway.Enabled {
+				setNatGatewayMigrationAnnotation(new, zone.Name)
+				return nil
+			}
+		}
+	}
+
+	return nil
+}
+
+func setNatGatewayMigrationAnnotation(infra *extensionsv1alpha1.Infrastructure, zone int32) {
+	if infra.Annotations == nil {
+		infra.Annotations = make(map[string]string)
+	}
+	infra.Annotations[azure.NatGatewayZoneMigrationAnnotation] = strconv.Itoa(int(zone))
+}
+
+// mutateZonedToFlat detects the reverse migration, collapsing a zoned layout back to a single Workers CIDR, which
+// is only unambiguous when exactly one zone remains and its CIDR equals the new Workers CIDR. The NAT gateway
+// migration annotation is always pruned here, since a flat layout has no per-zone gateway to import into. The
+// network layout annotation is either overwritten with the originating zone, or pruned too if the collapse is
+// ambiguous, so neither annotation is left dangling once the resource is no longer zoned.
+func mutateZonedToFlat(new *extensionsv1alpha1.Infrastructure, oldConfig, newConfig *azurev1alpha1.InfrastructureConfig) error {
+	delete(new.Annotations, azure.NatGatewayZoneMigrationAnnotation)
+
+	if newConfig.Networks.Workers == nil || len(oldConfig.Networks.Zones) != 1 {
+		removeLayoutMigrationAnnotation(new)
+		return nil
+	}
+
+	zone := oldConfig.Networks.Zones[0]
+	if zone.CIDR != *newConfig.Networks.Workers {
+		removeLayoutMigrationAnnotation(new)
+		return nil
+	}
+
+	setLayoutMigrationAnnotation(new, strconv.Itoa(int(zone.Name)))
+	return nil
+}
+
+// pruneStaleLayoutMigrationAnnotation removes the migration annotation once none of the zones it refers to are
+// still part of the zoned layout, e.g. because the migrated zone was later removed again.
+func pruneStaleLayoutMigrationAnnotation(new *extensionsv1alpha1.Infrastructure, newConfig *azurev1alpha1.InfrastructureConfig) error {
+	value, ok := new.GetAnnotations()[azure.NetworkLayoutZoneMigrationAnnotation]
+	if !ok {
+		return nil
+	}
+
+	present := make(map[string]struct{}, len(newConfig.Networks.Zones))
+	for _, zone := range newConfig.Networks.Zones {
+		present[strconv.Itoa(int(zone.Name))] = struct{}{}
+	}
+
+	if mapping, err := decodeLayoutMigrationMapping(value); err == nil {
+		filtered := make(map[string]string, len(mapping))
+		for zoneName, cidr := range mapping {
+			if _, ok := present[zoneName]; ok {
+				filtered[zoneName] = cidr
+			}
+		}
+		if len(filtered) == 0 {
+			removeLayoutMigrationAnnotation(new)
+			return nil
+		}
+		return setLayoutMigrationMappingRaw(new, filtered)
+	}
+
+	if _, ok := present[value]; !ok {
+		removeLayoutMigrationAnnotation(new)
+	}
+	return nil
+}
+
+// pruneStaleNatGatewayMigrationAnnotation removes the NAT gateway migration annotation once the zone it refers to
+// either no longer exists or no longer has an enabled NatGateway, mirroring pruneStaleLayoutMigrationAnnotation.
+func pruneStaleNatGatewayMigrationAnnotation(new *extensionsv1alpha1.Infrastructure, newConfig *azurev1alpha1.InfrastructureConfig) {
+	zoneName, ok := new.GetAnnotations()[azure.NatGatewayZoneMigrationAnnotation]
+	if !ok {
+		return
+	}
+
+	for _, zone := range newConfig.Networks.Zones {
+		if strconv.Itoa(int(zone.Name)) == zoneName && zone.NatGateway != nil && zone.NatGateway.Enabled {
+			return
+		}
+	}
+
+	delete(new.Annotations, azure.NatGatewayZoneMigrationAnnotation)
+}
+
+func decodeInfrastructureConfig(infra *extensionsv1alpha1.Infrastructure) (*azurev1alpha1.InfrastructureConfig, error) {
+	if infra.Spec.ProviderConfig == nil {
+		return nil, nil
+	}
+
+	config := &azurev1alpha1.InfrastructureConfig{}
+	if err := json.Unmarshal(infra.Spec.ProviderConfig.Raw, config); err != nil {
+		return nil, fmt.Errorf("could not decode provider config of infrastructure %q: %w", infra.Name, err)
+	}
+	return config, nil
+}
+
+func decodeLayoutMigrationMapping(value string) (map[string]string, error) {
+	var mapping map[string]string
+	if err := json.Unmarshal([]byte(value), &mapping); err != nil {
+		return nil, err
+	}
+	return mapping, nil
+}
+
+func setLayoutMigrationAnnotation(infra *extensionsv1alpha1.Infrastructure, value string) {
+	if infra.Annotations == nil {
+		infra.Annotations = make(map[string]string)
+	}
+	infra.Annotations[azure.NetworkLayoutZoneMigrationAnnotation] = value
+}
+
+func setLayoutMigrationMapping(infra *extensionsv1alpha1.Infrastructure, mapping map[int32]string) error {
+	strMapping := make(map[string]string, len(mapping))
+	for zone, cidr := range mapping {
+		strMapping[strconv.Itoa(int(zone))] = cidr
+	}
+	return setLayoutMigrationMappingRaw(infra, strMapping)
+}
+
+func setLayoutMigrationMappingRaw(infra *extensionsv1alpha1.Infrastructure, mapping map[string]string) error {
+	raw, err := json.Marshal(mapping)
+	if err != nil {
+		return fmt.Errorf("failed to marshal network layout migration mapping: %w", err)
+	}
+	setLayoutMigrationAnnotation(infra, string(raw))
+	return nil
+}
+
+func removeLayoutMigrationAnnotation(infra *extensionsv1alpha1.Infrastructure) {
+	delete(infra.Annotations, azure.NetworkLayoutZoneMigrationAnnotation)
+}
@@ -17,12 +17,15 @@ package infrastructure
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"strconv"
 	"testing"
 
 	azurev1alpha1 "github.com/gardener/gardener-extension-provider-azure/pkg/apis/azure/v1alpha1"
 	"github.com/gardener/gardener-extension-provider-azure/pkg/azure"
+	mockclient "github.com/gardener/gardener/extensions/pkg/mock/controller-runtime/client"
 	extensionswebhook "github.com/gardener/gardener/extensions/pkg/webhook"
+	gardencorev1beta1 "github.com/gardener/gardener/pkg/apis/core/v1beta1"
 	extensionsv1alpha1 "github.com/gardener/gardener/pkg/apis/extensions/v1alpha1"
 	"github.com/golang/mock/gomock"
 	. "github.com/onsi/ginkgo"
@@ -30,6 +33,8 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/utils/pointer"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/runtime/inject"
 )
 
 const (
@@ -134,6 +139,437 @@ var _ = Describe("Mutate", func() {
 			})
 		})
 
+		Context("add migration annotation for multiple zones", func() {
+			It("should record a JSON mapping when the Workers CIDR is carved across several zones", func() {
+				workersConfig := &azurev1alpha1.InfrastructureConfig{
+					TypeMeta: metav1.TypeMeta{
+						Kind:       "InfrastructureConfig",
+						APIVersion: azurev1alpha1.SchemeGroupVersion.String(),
+					},
+					Networks: azurev1alpha1.NetworkConfig{
+						Workers: pointer.String(workerCIDR),
+					},
+					Zoned: true,
+				}
+				zonesConfig := &azurev1alpha1.InfrastructureConfig{
+					TypeMeta: metav1.TypeMeta{
+						Kind:       "InfrastructureConfig",
+						APIVersion: azurev1alpha1.SchemeGroupVersion.String(),
+					},
+					Networks: azurev1alpha1.NetworkConfig{
+						Zones: []azurev1alpha1.Zone{
+							{
+								Name: int32(1),
+								CIDR: "10.0.0.0/17",
+							},
+							{
+								Name: int32(2),
+								CIDR: "10.0.128.0/17",
+							},
+						},
+					},
+					Zoned: true,
+				}
+
+				oldInfra := generateInfrastructureWithProviderConfig(workersConfig, nil)
+				newInfra := generateInfrastructureWithProviderConfig(zonesConfig, nil)
+
+				err := mutator.Mutate(context.TODO(), newInfra, oldInfra)
+
+				Expect(err).To(BeNil())
+				v, ok := getLayoutMigrationAnnotation(newInfra)
+				Expect(ok).To(BeTrue())
+
+				var mapping map[string]string
+				Expect(json.Unmarshal([]byte(v), &mapping)).To(Succeed())
+				Expect(mapping).To(Equal(map[string]string{
+					"1": "10.0.0.0/17",
+					"2": "10.0.128.0/17",
+				}))
+			})
+		})
+
+		Context("reverse migration from zoned to flat layout", func() {
+			It("should record the originating zone when collapsing to a single Workers CIDR", func() {
+				zonesConfig := &azurev1alpha1.InfrastructureConfig{
+					TypeMeta: metav1.TypeMeta{
+						Kind:       "InfrastructureConfig",
+						APIVersion: azurev1alpha1.SchemeGroupVersion.String(),
+					},
+					Networks: azurev1alpha1.NetworkConfig{
+						Zones: []azurev1alpha1.Zone{
+							{
+								Name: int32(2),
+								CIDR: workerCIDR,
+							},
+						},
+					},
+					Zoned: true,
+				}
+				workersConfig := &azurev1alpha1.InfrastructureConfig{
+					TypeMeta: metav1.TypeMeta{
+						Kind:       "InfrastructureConfig",
+						APIVersion: azurev1alpha1.SchemeGroupVersion.String(),
+					},
+					Networks: azurev1alpha1.NetworkConfig{
+						Workers: pointer.String(workerCIDR),
+					},
+					Zoned: false,
+				}
+
+				oldInfra := generateInfrastructureWithProviderConfig(zonesConfig, nil)
+				newInfra := generateInfrastructureWithProviderConfig(workersConfig, nil)
+
+				err := mutator.Mutate(context.TODO(), newInfra, oldInfra)
+
+				Expect(err).To(BeNil())
+				v, ok := getLayoutMigrationAnnotation(newInfra)
+				Expect(ok).To(BeTrue())
+				Expect(v).To(Equal("2"))
+			})
+
+			It("should do nothing if more than one zone remains", func() {
+				zonesConfig := &azurev1alpha1.InfrastructureConfig{
+					TypeMeta: metav1.TypeMeta{
+						Kind:       "InfrastructureConfig",
+						APIVersion: azurev1alpha1.SchemeGroupVersion.String(),
+					},
+					Networks: azurev1alpha1.NetworkConfig{
+						Zones: []azurev1alpha1.Zone{
+							{Name: int32(1), CIDR: "10.11.0.0/16"},
+							{Name: int32(2), CIDR: workerCIDR},
+						},
+					},
+					Zoned: true,
+				}
+				workersConfig := &azurev1alpha1.InfrastructureConfig{
+					TypeMeta: metav1.TypeMeta{
+						Kind:       "InfrastructureConfig",
+						APIVersion: azurev1alpha1.SchemeGroupVersion.String(),
+					},
+					Networks: azurev1alpha1.NetworkConfig{
+						Workers: pointer.String(workerCIDR),
+					},
+					Zoned: false,
+				}
+
+				oldInfra := generateInfrastructureWithProviderConfig(zonesConfig, nil)
+				newInfra := generateInfrastructureWithProviderConfig(workersConfig, nil)
+
+				err := mutator.Mutate(context.TODO(), newInfra, oldInfra)
+
+				Expect(err).To(BeNil())
+				_, ok := getLayoutMigrationAnnotation(newInfra)
+				Expect(ok).To(BeFalse())
+			})
+
+			It("should prune a stale network layout migration annotation left over from an earlier migration when the collapse is ambiguous", func() {
+				zonesConfig := &azurev1alpha1.InfrastructureConfig{
+					TypeMeta: metav1.TypeMeta{
+						Kind:       "InfrastructureConfig",
+						APIVersion: azurev1alpha1.SchemeGroupVersion.String(),
+					},
+					Networks: azurev1alpha1.NetworkConfig{
+						Zones: []azurev1alpha1.Zone{
+							{Name: int32(1), CIDR: "10.11.0.0/16"},
+							{Name: int32(2), CIDR: workerCIDR},
+						},
+					},
+					Zoned: true,
+				}
+				workersConfig := &azurev1alpha1.InfrastructureConfig{
+					TypeMeta: metav1.TypeMeta{
+						Kind:       "InfrastructureConfig",
+						APIVersion: azurev1alpha1.SchemeGroupVersion.String(),
+					},
+					Networks: azurev1alpha1.NetworkConfig{
+						Workers: pointer.String(workerCIDR),
+					},
+					Zoned: false,
+				}
+
+				oldInfra := generateInfrastructureWithProviderConfig(zonesConfig, nil)
+				newInfra := generateInfrastructureWithProviderConfig(workersConfig, nil)
+				addLayoutMigrationAnnotation(newInfra, 2)
+
+				err := mutator.Mutate(context.TODO(), newInfra, oldInfra)
+
+				Expect(err).To(BeNil())
+				_, ok := getLayoutMigrationAnnotation(newInfra)
+				Expect(ok).To(BeFalse())
+			})
+
+			It("should prune a stale NAT gateway migration annotation when collapsing to flat", func() {
+				zonesConfig := &azurev1alpha1.InfrastructureConfig{
+					TypeMeta: metav1.TypeMeta{
+						Kind:       "InfrastructureConfig",
+						APIVersion: azurev1alpha1.SchemeGroupVersion.String(),
+					},
+					Networks: azurev1alpha1.NetworkConfig{
+						Zones: []azurev1alpha1.Zone{
+							{
+								Name: int32(2),
+								CIDR: workerCIDR,
+							},
+						},
+					},
+					Zoned: true,
+				}
+				workersConfig := &azurev1alpha1.InfrastructureConfig{
+					TypeMeta: metav1.TypeMeta{
+						Kind:       "InfrastructureConfig",
+						APIVersion: azurev1alpha1.SchemeGroupVersion.String(),
+					},
+					Networks: azurev1alpha1.NetworkConfig{
+						Workers: pointer.String(workerCIDR),
+					},
+					Zoned: false,
+				}
+
+				oldInfra := generateInfrastructureWithProviderConfig(zonesConfig, nil)
+				newInfra := generateInfrastructureWithProviderConfig(workersConfig, nil)
+				addNatGatewayMigrationAnnotation(newInfra, 2)
+
+				err := mutator.Mutate(context.TODO(), newInfra, oldInfra)
+
+				Expect(err).To(BeNil())
+				_, ok := getNatGatewayMigrationAnnotation(newInfra)
+				Expect(ok).To(BeFalse())
+			})
+		})
+
+		Context("add NAT gateway migration annotation", func() {
+			It("should record the zone that inherits the flat layout's NAT gateway", func() {
+				workersConfig := &azurev1alpha1.InfrastructureConfig{
+					TypeMeta: metav1.TypeMeta{
+						Kind:       "InfrastructureConfig",
+						APIVersion: azurev1alpha1.SchemeGroupVersion.String(),
+					},
+					Networks: azurev1alpha1.NetworkConfig{
+						Workers: pointer.String(workerCIDR),
+						NatGateway: &azurev1alpha1.NatGatewayConfig{
+							Enabled: true,
+						},
+					},
+					Zoned: true,
+				}
+				zonesConfig := &azurev1alpha1.InfrastructureConfig{
+					TypeMeta: metav1.TypeMeta{
+						Kind:       "InfrastructureConfig",
+						APIVersion: azurev1alpha1.SchemeGroupVersion.String(),
+					},
+					Networks: azurev1alpha1.NetworkConfig{
+						Zones: []azurev1alpha1.Zone{
+							{
+								Name: int32(1),
+								CIDR: "10.11.0.0/16",
+							},
+							{
+								Name: int32(2),
+								CIDR: workerCIDR,
+								NatGateway: &azurev1alpha1.ZonedNatGatewayConfig{
+									Enabled: true,
+								},
+							},
+						},
+					},
+					Zoned: true,
+				}
+
+				oldInfra := generateInfrastructureWithProviderConfig(workersConfig, nil)
+				newInfra := generateInfrastructureWithProviderConfig(zonesConfig, nil)
+
+				err := mutator.Mutate(context.TODO(), newInfra, oldInfra)
+
+				Expect(err).To(BeNil())
+				v, ok := getNatGatewayMigrationAnnotation(newInfra)
+				Expect(ok).To(BeTrue())
+				Expect(v).To(Equal("2"))
+			})
+
+			It("should record the zone the user explicitly pinned to the gateway's availability zone", func() {
+				workersConfig := &azurev1alpha1.InfrastructureConfig{
+					TypeMeta: metav1.TypeMeta{
+						Kind:       "InfrastructureConfig",
+						APIVersion: azurev1alpha1.SchemeGroupVersion.String(),
+					},
+					Networks: azurev1alpha1.NetworkConfig{
+						Workers: pointer.String(workerCIDR),
+						NatGateway: &azurev1alpha1.NatGatewayConfig{
+							Enabled: true,
+							Zone:    pointer.Int32(2),
+						},
+					},
+					Zoned: true,
+				}
+				zonesConfig := &azurev1alpha1.InfrastructureConfig{
+					TypeMeta: metav1.TypeMeta{
+						Kind:       "InfrastructureConfig",
+						APIVersion: azurev1alpha1.SchemeGroupVersion.String(),
+					},
+					Networks: azurev1alpha1.NetworkConfig{
+						Zones: []azurev1alpha1.Zone{
+							{
+								Name: int32(1),
+								CIDR: "10.11.0.0/16",
+								NatGateway: &azurev1alpha1.ZonedNatGatewayConfig{
+									Enabled: true,
+									Zone:    pointer.Int32(2),
+								},
+							},
+							{
+								Name: int32(2),
+								CIDR: "10.12.0.0/16",
+							},
+						},
+					},
+					Zoned: true,
+				}
+
+				oldInfra := generateInfrastructureWithProviderConfig(workersConfig, nil)
+				newInfra := generateInfrastructureWithProviderConfig(zonesConfig, nil)
+
+				err := mutator.Mutate(context.TODO(), newInfra, oldInfra)
+
+				Expect(err).To(BeNil())
+				v, ok := getNatGatewayMigrationAnnotation(newInfra)
+				Expect(ok).To(BeTrue())
+				Expect(v).To(Equal("1"))
+			})
+
+			It("should do nothing if the flat layout had no NAT gateway", func() {
+				workersConfig := &azurev1alpha1.InfrastructureConfig{
+					TypeMeta: metav1.TypeMeta{
+						Kind:       "InfrastructureConfig",
+						APIVersion: azurev1alpha1.SchemeGroupVersion.String(),
+					},
+					Networks: azurev1alpha1.NetworkConfig{
+						Workers: pointer.String(workerCIDR),
+					},
+					Zoned: true,
+				}
+				zonesConfig := &azurev1alpha1.InfrastructureConfig{
+					TypeMeta: metav1.TypeMeta{
+						Kind:       "InfrastructureConfig",
+						APIVersion: azurev1alpha1.SchemeGroupVersion.String(),
+					},
+					Networks: azurev1alpha1.NetworkConfig{
+						Zones: []azurev1alpha1.Zone{
+							{
+								Name: int32(2),
+								CIDR: workerCIDR,
+								NatGateway: &azurev1alpha1.ZonedNatGatewayConfig{
+									Enabled: true,
+								},
+							},
+						},
+					},
+					Zoned: true,
+				}
+
+				oldInfra := generateInfrastructureWithProviderConfig(workersConfig, nil)
+				newInfra := generateInfrastructureWithProviderConfig(zonesConfig, nil)
+
+				err := mutator.Mutate(context.TODO(), newInfra, oldInfra)
+
+				Expect(err).To(BeNil())
+				_, ok := getNatGatewayMigrationAnnotation(newInfra)
+				Expect(ok).To(BeFalse())
+			})
+		})
+
+		Context("overlay opt-out", func() {
+			var (
+				mockClient                 *mockclient.MockClient
+				workersConfig, zonesConfig *azurev1alpha1.InfrastructureConfig
+			)
+
+			BeforeEach(func() {
+				mockClient = mockclient.NewMockClient(ctrl)
+				Expect(mutator.(inject.Client).InjectClient(mockClient)).To(Succeed())
+
+				workersConfig = &azurev1alpha1.InfrastructureConfig{
+					TypeMeta: metav1.TypeMeta{
+						Kind:       "InfrastructureConfig",
+						APIVersion: azurev1alpha1.SchemeGroupVersion.String(),
+					},
+					Networks: azurev1alpha1.NetworkConfig{
+						Workers: pointer.String(workerCIDR),
+					},
+					Zoned: true,
+				}
+				zonesConfig = &azurev1alpha1.InfrastructureConfig{
+					TypeMeta: metav1.TypeMeta{
+						Kind:       "InfrastructureConfig",
+						APIVersion: azurev1alpha1.SchemeGroupVersion.String(),
+					},
+					Networks: azurev1alpha1.NetworkConfig{
+						Zones: []azurev1alpha1.Zone{
+							{Name: int32(2), CIDR: workerCIDR},
+						},
+					},
+					Zoned: true,
+				}
+			})
+
+			expectCluster := func(overlayEnabled bool) {
+				shoot := &gardencorev1beta1.Shoot{
+					Spec: gardencorev1beta1.ShootSpec{
+						Networking: &gardencorev1beta1.Networking{
+							ProviderConfig: &runtime.RawExtension{
+								Raw: []byte(fmt.Sprintf(`{"overlay":{"enabled":%t}}`, overlayEnabled)),
+							},
+						},
+					},
+				}
+				shootRaw, err := json.Marshal(shoot)
+				Expect(err).To(BeNil())
+
+				mockClient.EXPECT().Get(gomock.Any(), gomock.Any(), gomock.AssignableToTypeOf(&extensionsv1alpha1.Cluster{})).DoAndReturn(
+					func(_ context.Context, _ client.ObjectKey, cluster *extensionsv1alpha1.Cluster, _ ...client.GetOption) error {
+						cluster.Spec.Shoot = runtime.RawExtension{Raw: shootRaw}
+						return nil
+					})
+			}
+
+			It("should mutate on update when overlay is enabled", func() {
+				expectCluster(true)
+
+				newInfra := generateInfrastructureWithProviderConfig(zonesConfig, nil)
+				oldInfra := generateInfrastructureWithProviderConfig(workersConfig, nil)
+
+				err := mutator.Mutate(context.TODO(), newInfra, oldInfra)
+
+				Expect(err).To(BeNil())
+				v, ok := getLayoutMigrationAnnotation(newInfra)
+				Expect(ok).To(BeTrue())
+				Expect(v).To(Equal("2"))
+			})
+
+			It("should skip mutation on update when overlay is disabled", func() {
+				expectCluster(false)
+
+				newInfra := generateInfrastructureWithProviderConfig(zonesConfig, nil)
+				oldInfra := generateInfrastructureWithProviderConfig(workersConfig, nil)
+
+				err := mutator.Mutate(context.TODO(), newInfra, oldInfra)
+
+				Expect(err).To(BeNil())
+				_, ok := getLayoutMigrationAnnotation(newInfra)
+				Expect(ok).To(BeFalse())
+			})
+
+			It("should skip mutation on create regardless of overlay status, without looking up the cluster", func() {
+				newInfra := generateInfrastructureWithProviderConfig(zonesConfig, nil)
+
+				err := mutator.Mutate(context.TODO(), newInfra, nil)
+
+				Expect(err).To(BeNil())
+				_, ok := getLayoutMigrationAnnotation(newInfra)
+				Expect(ok).To(BeFalse())
+			})
+		})
+
 		Context("remove migration annotation", func() {
 			var (
 				migratedSubnet int
@@ -182,6 +618,73 @@ var _ = Describe("Mutate", func() {
 				Expect(a).To(Equal(strconv.Itoa(migratedSubnet)))
 			})
 		})
+
+		Context("remove NAT gateway migration annotation", func() {
+			var (
+				migratedZone int32
+				zonesInfra   *extensionsv1alpha1.Infrastructure
+				zonesConfig  *azurev1alpha1.InfrastructureConfig
+			)
+
+			BeforeEach(func() {
+				migratedZone = int32(2)
+
+				zonesConfig = &azurev1alpha1.InfrastructureConfig{
+					TypeMeta: metav1.TypeMeta{
+						Kind:       "InfrastructureConfig",
+						APIVersion: azurev1alpha1.SchemeGroupVersion.String(),
+					},
+					Zoned: true,
+					Networks: azurev1alpha1.NetworkConfig{
+						Zones: []azurev1alpha1.Zone{
+							{Name: int32(1)},
+							{
+								Name: migratedZone,
+								NatGateway: &azurev1alpha1.ZonedNatGatewayConfig{
+									Enabled: true,
+								},
+							},
+						},
+					},
+				}
+				zonesInfra = generateInfrastructureWithProviderConfig(zonesConfig, nil)
+				addNatGatewayMigrationAnnotation(zonesInfra, migratedZone)
+			})
+
+			It("should remove the annotation when the zone no longer has an enabled NAT gateway", func() {
+				zonesConfig.Networks.Zones[1].NatGateway = nil
+				newZonesInfra := generateInfrastructureWithProviderConfig(zonesConfig, nil)
+				addNatGatewayMigrationAnnotation(newZonesInfra, migratedZone)
+
+				err := mutator.Mutate(context.TODO(), newZonesInfra, zonesInfra)
+				Expect(err).To(BeNil())
+				_, ok := getNatGatewayMigrationAnnotation(newZonesInfra)
+				Expect(ok).To(BeFalse())
+			})
+
+			It("should remove the annotation when the zone is no longer in use", func() {
+				zonesConfig.Networks.Zones = zonesConfig.Networks.Zones[:1]
+				newZonesInfra := generateInfrastructureWithProviderConfig(zonesConfig, nil)
+				addNatGatewayMigrationAnnotation(newZonesInfra, migratedZone)
+
+				err := mutator.Mutate(context.TODO(), newZonesInfra, zonesInfra)
+				Expect(err).To(BeNil())
+				_, ok := getNatGatewayMigrationAnnotation(newZonesInfra)
+				Expect(ok).To(BeFalse())
+			})
+
+			It("should keep the annotation if the zone still has an enabled NAT gateway", func() {
+				zonesConfig.Networks.Zones[1].NatGateway = &azurev1alpha1.ZonedNatGatewayConfig{Enabled: true}
+				newZonesInfra := generateInfrastructureWithProviderConfig(zonesConfig, nil)
+				addNatGatewayMigrationAnnotation(newZonesInfra, migratedZone)
+
+				err := mutator.Mutate(context.TODO(), newZonesInfra, zonesInfra)
+				Expect(err).To(BeNil())
+				v, ok := getNatGatewayMigrationAnnotation(newZonesInfra)
+				Expect(ok).To(BeTrue())
+				Expect(v).To(Equal(strconv.Itoa(int(migratedZone))))
+			})
+		})
 	})
 })
 
@@ -213,6 +716,17 @@ func getLayoutMigrationAnnotation(o *extensionsv1alpha1.Infrastructure) (string,
 	return getAnnotation(azure.NetworkLayoutZoneMigrationAnnotation, o)
 }
 
+func getNatGatewayMigrationAnnotation(o *extensionsv1alpha1.Infrastructure) (string, bool) {
+	return getAnnotation(azure.NatGatewayZoneMigrationAnnotation, o)
+}
+
+func addNatGatewayMigrationAnnotation(o *extensionsv1alpha1.Infrastructure, zone int32) {
+	if o.Annotations == nil {
+		o.Annotations = make(map[string]string)
+	}
+	o.Annotations[azure.NatGatewayZoneMigrationAnnotation] = strconv.Itoa(int(zone))
+}
+
 func addLayoutMigrationAnnotation(o *extensionsv1alpha1.Infrastructure, zone int) {
 	if o.Annotations == nil {
 		o.Annotations = make(map[string]string)